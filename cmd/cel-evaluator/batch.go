@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BatchResponse is the JSON structure sent back to Python for a "batch" op.
+//
+// Results holds one entry per row in request order; a nil entry marks a row
+// that failed, with the reason recorded in Errors keyed by the row's string
+// index. If the deadline passes between rows, the response is
+// still "ok":true — TimeoutIndex is set to the index evaluation stopped at,
+// Code is set to "timeout", and Results/Errors cover only the rows before
+// it, so the caller can tell a partial-but-successful batch from a hard
+// failure and decide whether to retry the remaining tail starting at
+// TimeoutIndex. This is the one case where Code is populated alongside
+// "ok":true; everywhere else in the protocol, Code only accompanies
+// "ok":false. If a single row's evaluation runs past the deadline plus
+// batchTimeoutGrace, the batch fails with "ok":false, Code "timeout" and no
+// Results.
+type BatchResponse struct {
+	ID           string            `json:"id"`
+	OK           bool              `json:"ok"`
+	Results      []*bool           `json:"results,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+	TimeoutIndex *int              `json:"timeout_index,omitempty"`
+	Engine       string            `json:"engine,omitempty"`
+	Code         ErrorCode         `json:"code,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// batchTimeoutGrace is how long evaluateBatch waits after the deadline for
+// evaluateBatchSync to hand back its partial result.
+const batchTimeoutGrace = 100 * time.Millisecond
+
+// evaluateBatch wraps evaluateBatchSync with the same timeout budget used for
+// a single evaluation, applied to the whole batch rather than per row.
+func evaluateBatch(req Request, cache *programCache) BatchResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), evaluationTimeout)
+	defer cancel()
+
+	resultCh := make(chan BatchResponse, 1)
+
+	go func() {
+		resultCh <- evaluateBatchSync(ctx, req, cache)
+	}()
+
+	select {
+	case <-ctx.Done():
+		// evaluateBatchSync checks ctx between rows and answers with a
+		// partial result shortly after the deadline; wait for it. The hard
+		// timeout below only fires if a single row's Eval() itself hangs
+		// past the grace period.
+		select {
+		case resp := <-resultCh:
+			return resp
+		case <-time.After(batchTimeoutGrace):
+		}
+		return BatchResponse{ID: req.ID, OK: false, Code: ErrTimeout, Error: fmt.Sprintf("CEL batch evaluation timeout (%v)", evaluationTimeout)}
+	case resp := <-resultCh:
+		return resp
+	}
+}
+
+// evaluateBatchSync compiles Expr once against Vars and evaluates it for
+// every row in Rows, reusing the compiled program across rows the same way
+// wildcard-expansion callers reuse it across separate requests. A per-row
+// error does not abort the batch; it is recorded in Errors (and counted in
+// cache's per-code stats) and evaluation continues with the next row.
+func evaluateBatchSync(ctx context.Context, req Request, cache *programCache) BatchResponse {
+	eng, err := resolveEngine(req.Engine)
+	if err != nil {
+		return BatchResponse{ID: req.ID, OK: false, Code: codeOf(err), Error: err.Error()}
+	}
+
+	prg, err := getProgram(cache, eng, req.Expr, req.Vars)
+	if err != nil {
+		return BatchResponse{ID: req.ID, OK: false, Engine: eng.Name(), Code: codeOf(err), Error: err.Error()}
+	}
+
+	results := make([]*bool, 0, len(req.Rows))
+	var errs map[string]string
+
+	for i, row := range req.Rows {
+		select {
+		case <-ctx.Done():
+			idx := i
+			cache.recordError(ErrTimeout)
+			return BatchResponse{ID: req.ID, OK: true, Engine: eng.Name(), Code: ErrTimeout, Results: results, Errors: errs, TimeoutIndex: &idx}
+		default:
+		}
+
+		result, err := evalBool(prg, row)
+		if err != nil {
+			results = append(results, nil)
+			if errs == nil {
+				errs = make(map[string]string)
+			}
+			errs[strconv.Itoa(i)] = err.Error()
+			cache.recordError(codeOf(err))
+			continue
+		}
+		results = append(results, &result)
+	}
+
+	return BatchResponse{ID: req.ID, OK: true, Engine: eng.Name(), Results: results, Errors: errs}
+}
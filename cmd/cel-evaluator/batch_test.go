@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEvaluateBatchSyncTimeoutMidBatch(t *testing.T) {
+	cache := newProgramCache(10, 0)
+
+	rows := make([]map[string]any, 5)
+	for i := range rows {
+		rows[i] = map[string]any{"a": 1, "b": 1}
+	}
+	req := Request{ID: "batch-1", Expr: "a == b", Vars: []string{"a", "b"}, Rows: rows}
+
+	// Cancel the context up front so the first ctx.Done() check inside the
+	// row loop fires, exercising the same branch that commit 93952e9 fixed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resp := evaluateBatchSync(ctx, req, cache)
+
+	if !resp.OK {
+		t.Fatalf("expected ok:true for a partial-timeout batch, got ok:false (error=%q)", resp.Error)
+	}
+	if resp.Code != ErrTimeout {
+		t.Fatalf("code = %q, want %q", resp.Code, ErrTimeout)
+	}
+	if resp.TimeoutIndex == nil {
+		t.Fatal("expected TimeoutIndex to be set")
+	}
+	if *resp.TimeoutIndex != 0 {
+		t.Fatalf("timeout_index = %d, want 0 (context was already canceled)", *resp.TimeoutIndex)
+	}
+	if len(resp.Results) != 0 {
+		t.Fatalf("expected no rows evaluated before the timeout, got %d results", len(resp.Results))
+	}
+
+	stats := cache.snapshot()
+	if stats.Errors[ErrTimeout] != 1 {
+		t.Fatalf("expected cache.recordError(ErrTimeout) to have fired once, got count=%d", stats.Errors[ErrTimeout])
+	}
+}
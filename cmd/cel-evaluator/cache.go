@@ -0,0 +1,217 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is used when APIPARITY_CEL_CACHE_SIZE is unset or invalid.
+const defaultCacheSize = 256
+
+// defaultCompileHistogramSize bounds how many recent compile durations we
+// retain for the p50 estimate in the stats op. This is a rolling window, not
+// a lifetime total, so long-running processes don't grow it unbounded.
+const defaultCompileHistogramSize = 512
+
+// cacheEntry is the value stored in the LRU list; key is kept alongside the
+// program so eviction from the back of the list can remove it from the map.
+type cacheEntry struct {
+	key       string
+	prg       Program
+	expiresAt time.Time // zero means no TTL
+}
+
+// cacheStats is a point-in-time snapshot of cache counters for the "stats" op.
+type cacheStats struct {
+	Hits         uint64               `json:"hits"`
+	Misses       uint64               `json:"misses"`
+	Evictions    uint64               `json:"evictions"`
+	Expired      uint64               `json:"expired"`
+	Size         int                  `json:"size"`
+	CompileNsP50 int64                `json:"compile_ns_p50"`
+	Errors       map[ErrorCode]uint64 `json:"errors,omitempty"`
+}
+
+// programCache caches compiled programs keyed by (engine, expression, variable
+// names), evicting the least-recently-used entry once maxSize is exceeded and
+// lazily expiring entries past ttl.
+//
+// WHY: Wildcard JSONPath expansion can produce thousands of evaluations of the
+// same expression with the same variable names (e.g., "a == b" with vars {a, b})
+// but different values. Without caching, each evaluation creates a new cel.Env,
+// compiles the expression, and builds a program — causing heavy GC pressure that
+// can OOM-kill the process on large responses. With caching, we compile once and
+// call prg.Eval() with different data for subsequent hits.
+//
+// Previously the cache went read-only once full, silently dropping new
+// entries. A run that touches more distinct (expr, varNames) pairs than the
+// cap paid full compile cost on every subsequent evaluation of those pairs.
+// An LRU evicts the coldest entry instead, so hot programs stay cached
+// regardless of how many cold ones were seen in between.
+//
+// Thread safety: a single mutex guards both the list and map since get()
+// mutates list order (MoveToFront) even on a "read".
+type programCache struct {
+	mu      sync.Mutex
+	ll      *list.List // front = most recently used
+	items   map[string]*list.Element
+	maxSize int
+	ttl     time.Duration // zero means entries never expire
+
+	stats       cacheStats
+	errorCounts map[ErrorCode]uint64
+
+	compileTimes [defaultCompileHistogramSize]time.Duration
+	compileCount int
+	compileNext  int
+}
+
+// newProgramCache builds a cache with the given capacity and TTL. A ttl of
+// zero disables expiration.
+func newProgramCache(maxSize int, ttl time.Duration) *programCache {
+	if maxSize <= 0 {
+		maxSize = defaultCacheSize
+	}
+	return &programCache{
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		maxSize:     maxSize,
+		ttl:         ttl,
+		errorCounts: make(map[ErrorCode]uint64),
+	}
+}
+
+// newProgramCacheFromEnv reads APIPARITY_CEL_CACHE_SIZE (int, default 256)
+// and APIPARITY_CEL_CACHE_TTL (a time.ParseDuration string, e.g. "10m";
+// empty or unset disables TTL) to build the cache for this process.
+func newProgramCacheFromEnv() *programCache {
+	size := defaultCacheSize
+	if raw := os.Getenv("APIPARITY_CEL_CACHE_SIZE"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			size = n
+		}
+	}
+
+	var ttl time.Duration
+	if raw := os.Getenv("APIPARITY_CEL_CACHE_TTL"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			ttl = d
+		}
+	}
+
+	return newProgramCache(size, ttl)
+}
+
+// get returns a cached, non-expired program and true if found. An expired
+// entry is treated as a miss and removed from the cache. A hit promotes the
+// entry to the front of the LRU list.
+func (c *programCache) get(key string) (Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		c.stats.Expired++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return entry.prg, true
+}
+
+// put stores a compiled program, evicting the least-recently-used entry if
+// the cache is at capacity.
+func (c *programCache) put(key string, prg Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).prg = prg
+		el.Value.(*cacheEntry).expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&cacheEntry{key: key, prg: prg, expiresAt: expiresAt})
+	c.items[key] = el
+
+	if c.ll.Len() > c.maxSize {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.removeElement(oldest)
+			c.stats.Evictions++
+		}
+	}
+}
+
+// removeElement removes el from both the list and the map. Callers must hold c.mu.
+func (c *programCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+// recordCompile adds a compile-time sample to the rolling histogram used for
+// the p50 estimate reported by the stats op.
+func (c *programCache) recordCompile(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.compileTimes[c.compileNext] = d
+	c.compileNext = (c.compileNext + 1) % defaultCompileHistogramSize
+	if c.compileCount < defaultCompileHistogramSize {
+		c.compileCount++
+	}
+}
+
+// recordError increments the counter for the given error class, so the
+// stats op can report e.g. "17 compile errors, 3 timeouts" without the
+// caller needing to regex-match error strings.
+func (c *programCache) recordError(code ErrorCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errorCounts[code]++
+}
+
+// snapshot returns the current counters and a p50 compile-time estimate over
+// the rolling window of recent compiles.
+func (c *programCache) snapshot() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Size = c.ll.Len()
+
+	if c.compileCount > 0 {
+		samples := make([]time.Duration, c.compileCount)
+		copy(samples, c.compileTimes[:c.compileCount])
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		s.CompileNsP50 = samples[c.compileCount/2].Nanoseconds()
+	}
+
+	if len(c.errorCounts) > 0 {
+		s.Errors = make(map[ErrorCode]uint64, len(c.errorCounts))
+		for code, n := range c.errorCounts {
+			s.Errors[code] = n
+		}
+	}
+
+	return s
+}
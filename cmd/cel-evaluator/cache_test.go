@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeProgram is a minimal Program for exercising the cache without
+// compiling a real CEL or expr-lang expression.
+type fakeProgram struct{ tag string }
+
+func (f fakeProgram) Eval(data map[string]any) (any, error) { return true, nil }
+
+func TestProgramCacheEvictionOrder(t *testing.T) {
+	c := newProgramCache(2, 0)
+
+	c.put("a", fakeProgram{"a"})
+	c.put("b", fakeProgram{"b"})
+	c.put("c", fakeProgram{"c"}) // evicts "a", the least-recently-used
+
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected \"a\" to be evicted")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Fatal("expected \"b\" to still be cached")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Fatal("expected \"c\" to still be cached")
+	}
+
+	stats := c.snapshot()
+	if stats.Evictions != 1 {
+		t.Fatalf("expected 1 eviction, got %d", stats.Evictions)
+	}
+}
+
+func TestProgramCacheGetPromotesToFront(t *testing.T) {
+	c := newProgramCache(2, 0)
+
+	c.put("a", fakeProgram{"a"})
+	c.put("b", fakeProgram{"b"})
+	c.get("a")                  // "a" is now most-recently-used; "b" is next to go
+	c.put("c", fakeProgram{"c"}) // evicts "b", not "a"
+
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected \"a\" to survive eviction after being promoted by get()")
+	}
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected \"b\" to be evicted")
+	}
+}
+
+func TestProgramCacheTTLExpiry(t *testing.T) {
+	c := newProgramCache(10, 5*time.Millisecond)
+
+	c.put("a", fakeProgram{"a"})
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected immediate get() to hit before TTL elapses")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected get() to miss once the TTL has elapsed")
+	}
+
+	stats := c.snapshot()
+	if stats.Expired != 1 {
+		t.Fatalf("expected 1 expired entry, got %d", stats.Expired)
+	}
+	if stats.Size != 0 {
+		t.Fatalf("expected expired entry to be removed from the cache, size=%d", stats.Size)
+	}
+}
+
+func TestProgramCacheCounters(t *testing.T) {
+	c := newProgramCache(10, 0)
+
+	c.get("missing")
+	c.put("a", fakeProgram{"a"})
+	c.get("a")
+	c.get("a")
+	c.get("still-missing")
+
+	stats := c.snapshot()
+	if stats.Hits != 2 {
+		t.Errorf("hits = %d, want 2", stats.Hits)
+	}
+	if stats.Misses != 2 {
+		t.Errorf("misses = %d, want 2", stats.Misses)
+	}
+	if stats.Size != 1 {
+		t.Errorf("size = %d, want 1", stats.Size)
+	}
+}
+
+func TestProgramCacheRecordError(t *testing.T) {
+	c := newProgramCache(10, 0)
+
+	c.recordError(ErrTimeout)
+	c.recordError(ErrTimeout)
+	c.recordError(ErrCompileError)
+
+	stats := c.snapshot()
+	if stats.Errors[ErrTimeout] != 2 {
+		t.Errorf("timeout errors = %d, want 2", stats.Errors[ErrTimeout])
+	}
+	if stats.Errors[ErrCompileError] != 1 {
+		t.Errorf("compile errors = %d, want 1", stats.Errors[ErrCompileError])
+	}
+}
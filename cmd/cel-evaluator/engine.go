@@ -0,0 +1,192 @@
+package main
+
+import (
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/google/cel-go/cel"
+)
+
+// defaultEngineName is used when a Request omits the "engine" field.
+const defaultEngineName = "cel"
+
+// Program is a compiled expression ready to evaluate against row data. Eval
+// returns the engine's native result value; callers enforce the "must return
+// boolean" contract uniformly via asBool. The expr engine additionally
+// rejects non-boolean expressions at compile time (expr.AsBool), so for it a
+// non-boolean expression is reported as compile_error rather than type_error.
+type Program interface {
+	Eval(data map[string]any) (any, error)
+}
+
+// Engine compiles expressions for one expression language. Implementations
+// are stateless and safe to share across requests; compiled Programs are
+// cached by the caller, not the Engine.
+type Engine interface {
+	// Name identifies the engine in cache keys and in the Response so Python
+	// can see which engine actually ran.
+	Name() string
+	Compile(exprStr string, vars []string) (Program, error)
+
+	// CacheVars narrows vars to the subset that actually affects the
+	// compiled Program, for cache-key purposes. Two requests that differ
+	// only in vars outside that subset should hit the same cache entry
+	// instead of needlessly recompiling and evicting each other.
+	CacheVars(vars []string) []string
+}
+
+// engines maps the "engine" request field to its implementation.
+var engines = map[string]Engine{
+	"cel":  celEngine{},
+	"expr": exprEngine{},
+}
+
+// resolveEngine looks up the engine named by a Request's Engine field,
+// defaulting to CEL when unset.
+func resolveEngine(name string) (Engine, error) {
+	if name == "" {
+		name = defaultEngineName
+	}
+	eng, ok := engines[name]
+	if !ok {
+		return nil, newCodedError(ErrEnvError, "unknown engine %q", name)
+	}
+	return eng, nil
+}
+
+// asBool extracts the boolean result required of every api-parity
+// expression, regardless of which engine produced it.
+func asBool(v any) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, newCodedError(ErrTypeError, "expression must return boolean, got %T", v)
+	}
+	return b, nil
+}
+
+// getProgram fetches a compiled program for (eng, expr, varNames) from
+// cache, compiling and storing it on a miss. The cache key only includes
+// the subset of varNames the engine says affects compilation — see
+// Engine.CacheVars — so two requests with the same expression but
+// irrelevant differences in variable names can still share a cache entry.
+func getProgram(cache *programCache, eng Engine, exprStr string, varNames []string) (Program, error) {
+	key := cacheKey(eng.Name(), exprStr, eng.CacheVars(varNames))
+
+	if prg, cached := cache.get(key); cached {
+		return prg, nil
+	}
+
+	compileStart := time.Now()
+
+	prg, err := eng.Compile(exprStr, varNames)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.recordCompile(time.Since(compileStart))
+	cache.put(key, prg)
+	return prg, nil
+}
+
+// evalBool runs prg against data and extracts the boolean result. Every
+// engine's expressions in api-parity must return boolean (true = values
+// match).
+func evalBool(prg Program, data map[string]any) (bool, error) {
+	out, err := prg.Eval(data)
+	if err != nil {
+		return false, err
+	}
+	return asBool(out)
+}
+
+// celEngine compiles and runs CEL expressions.
+type celEngine struct{}
+
+func (celEngine) Name() string { return "cel" }
+
+// CacheVars returns vars unchanged: CEL declares each variable's type in the
+// compilation environment, so the compiled program depends on exactly which
+// variable names are present.
+func (celEngine) CacheVars(vars []string) []string { return vars }
+
+func (celEngine) Compile(exprStr string, vars []string) (Program, error) {
+	// DynType for all variables since JSON values can be any type.
+	opts := []cel.EnvOption{
+		cel.DefaultUTCTimeZone(true),
+	}
+	for _, name := range vars {
+		opts = append(opts, cel.Variable(name, cel.DynType))
+	}
+
+	env, err := cel.NewEnv(opts...)
+	if err != nil {
+		return nil, newCodedError(ErrEnvError, "CEL environment creation failed: %v", err)
+	}
+
+	ast, issues := env.Compile(exprStr)
+	if issues != nil && issues.Err() != nil {
+		return nil, newCodedError(ErrCompileError, "CEL compile error in expression %q: %v", exprStr, issues.Err())
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, newCodedError(ErrCompileError, "CEL program creation failed: %v", err)
+	}
+
+	return celProgram{prg: prg}, nil
+}
+
+// celProgram adapts cel.Program to the Program interface.
+//
+// cel.Program is stateless and thread-safe per cel-go docs — safe to call
+// Eval() concurrently on a cached program from multiple goroutines.
+type celProgram struct {
+	prg cel.Program
+}
+
+func (p celProgram) Eval(data map[string]any) (any, error) {
+	out, _, err := p.prg.Eval(data)
+	if err != nil {
+		return nil, newCodedError(ErrEvalError, "CEL evaluation error: %v", err)
+	}
+	return out.Value(), nil
+}
+
+// exprEngine compiles and runs expr-lang/expr expressions. It's looser than
+// CEL about typing and undeclared variables, and supports expr-lang helpers
+// like "?." navigation and sprintf-style formatting that some field rules
+// are more naturally written with.
+type exprEngine struct{}
+
+func (exprEngine) Name() string { return "expr" }
+
+// CacheVars always returns nil: expr.Compile is called with
+// AllowUndefinedVariables rather than per-variable declarations, so the
+// compiled program for a given expression is identical regardless of which
+// variable names the caller happens to pass.
+func (exprEngine) CacheVars(vars []string) []string { return nil }
+
+func (exprEngine) Compile(src string, vars []string) (Program, error) {
+	// vars isn't passed to expr.Compile: AllowUndefinedVariables lets the
+	// expression reference whatever keys show up in the row data without
+	// pre-declaring them, matching CEL's DynType-for-everything behavior.
+	prg, err := expr.Compile(src, expr.AllowUndefinedVariables(), expr.AsBool())
+	if err != nil {
+		return nil, newCodedError(ErrCompileError, "expr compile error in expression %q: %v", src, err)
+	}
+	return exprProgram{prg: prg}, nil
+}
+
+// exprProgram adapts *vm.Program to the Program interface.
+type exprProgram struct {
+	prg *vm.Program
+}
+
+func (p exprProgram) Eval(data map[string]any) (any, error) {
+	out, err := expr.Run(p.prg, data)
+	if err != nil {
+		return nil, newCodedError(ErrEvalError, "expr evaluation error: %v", err)
+	}
+	return out, nil
+}
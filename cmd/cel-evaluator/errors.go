@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode classifies a failure so the Python side can distinguish, say, a
+// compile error from a timeout without regex-matching the free-form message.
+type ErrorCode string
+
+const (
+	ErrCompileError    ErrorCode = "compile_error"
+	ErrEnvError        ErrorCode = "env_error"
+	ErrEvalError       ErrorCode = "eval_error"
+	ErrTypeError       ErrorCode = "type_error"
+	ErrTimeout         ErrorCode = "timeout"
+	ErrInvalidJSON     ErrorCode = "invalid_json"
+	ErrPayloadTooLarge ErrorCode = "payload_too_large"
+)
+
+// codedError pairs an ErrorCode with a human-readable message, so a single
+// error value carries both the machine-checkable class reported in "code"
+// and the full detail reported in "error".
+type codedError struct {
+	code ErrorCode
+	msg  string
+}
+
+// newCodedError builds a codedError with a formatted message, mirroring the
+// fmt.Errorf call sites it replaces.
+func newCodedError(code ErrorCode, format string, args ...any) *codedError {
+	return &codedError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+// codeOf extracts the ErrorCode from err, defaulting to eval_error for an
+// error that wasn't constructed with newCodedError. Every error path in this
+// package is expected to use a codedError; the default just keeps the stats
+// counters sane instead of panicking if a future path forgets one.
+func codeOf(err error) ErrorCode {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return ErrEvalError
+}
@@ -8,102 +8,91 @@
 //   Request: {"id":"<uuid>","expr":"a == b","data":{"a":1,"b":1}}\n
 //   Response: {"id":"<uuid>","ok":true,"result":true}\n
 //   Error: {"id":"<uuid>","ok":false,"error":"..."}\n
+//   Control: {"op":"stats"} -> {"hits":..,"misses":..,"evictions":..,"size":..,"compile_ns_p50":..,"errors":{"timeout":3,...}}
+//   Batch: {"id":"..","op":"batch","expr":"a == b","vars":["a","b"],"rows":[{"a":1,"b":1},...]}
+//       -> {"id":"..","ok":true,"results":[true,false,...],"errors":{"3":"..."}}
+//       A batch whose deadline passes between rows instead returns
+//       {"id":"..","ok":true,"code":"timeout","results":[...],"errors":{...},"timeout_index":N},
+//       covering only rows before N — the caller decides whether to retry the tail.
+//       A batch in which a single row's evaluation hangs past the deadline
+//       fails with {"id":"..","ok":false,"code":"timeout","error":"..."} and no results.
+//
+// Requests may set "engine" to "cel" (default) or "expr" to select the
+// expression language; the chosen engine is echoed back in the response.
+//
+// Every failure response carries a "code" field (see ErrorCode) alongside
+// the free-form "error" string, so callers can distinguish e.g. a compile
+// error from a timeout without matching on message text. Per-code counts
+// are included in the stats op's "errors" field. The one exception is the
+// partial-timeout batch response above: it sets "code":"timeout" together
+// with "ok":true, since the batch itself partially succeeded — callers that
+// check "code" before keying off "ok" handle both cases uniformly.
+//
+// The compiled-program cache is sized via APIPARITY_CEL_CACHE_SIZE (default
+// 256 entries) and optionally TTL'd via APIPARITY_CEL_CACHE_TTL (a
+// time.ParseDuration string, e.g. "10m"; unset disables TTL).
 package main
 
 import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
-	"sync"
 	"time"
-
-	"github.com/google/cel-go/cel"
-	"github.com/google/cel-go/common/types"
 )
 
 // Request is the JSON structure received from Python.
+//
+// Op, if set, selects a control message instead of a single-row evaluation:
+// "stats" returns cache counters, "batch" evaluates Expr against Rows. A
+// Request with no Op evaluates Expr against Data as before.
+//
+// Engine selects the expression language ("cel", the default, or "expr");
+// see resolveEngine.
 type Request struct {
-	ID   string         `json:"id"`
-	Expr string         `json:"expr"`
-	Data map[string]any `json:"data"`
+	ID     string           `json:"id"`
+	Op     string           `json:"op,omitempty"`
+	Engine string           `json:"engine,omitempty"`
+	Expr   string           `json:"expr"`
+	Data   map[string]any   `json:"data"`
+	Vars   []string         `json:"vars,omitempty"`
+	Rows   []map[string]any `json:"rows,omitempty"`
 }
 
 // Response is the JSON structure sent back to Python.
 type Response struct {
-	ID     string `json:"id"`
-	OK     bool   `json:"ok"`
-	Result *bool  `json:"result,omitempty"`
-	Error  string `json:"error,omitempty"`
+	ID     string    `json:"id"`
+	OK     bool      `json:"ok"`
+	Result *bool     `json:"result,omitempty"`
+	Engine string    `json:"engine,omitempty"`
+	Code   ErrorCode `json:"code,omitempty"`
+	Error  string    `json:"error,omitempty"`
 }
 
 // evaluationTimeout catches pathological expressions without blocking Python indefinitely
 const evaluationTimeout = 5 * time.Second
 
-// maxCacheSize bounds the compiled-program cache. In practice, the number of
-// unique (expression, variable-names) pairs in a single run equals the number
-// of field_rules in the comparison config — typically well under 100. The cap
-// is a safety net, not a performance knob.
-const maxCacheSize = 256
-
-// programCache caches compiled CEL programs keyed by (expression, variable names).
-//
-// WHY: Wildcard JSONPath expansion can produce thousands of evaluations of the
-// same expression with the same variable names (e.g., "a == b" with vars {a, b})
-// but different values. Without caching, each evaluation creates a new cel.Env,
-// compiles the expression, and builds a program — causing heavy GC pressure that
-// can OOM-kill the process on large responses. With caching, we compile once and
-// call prg.Eval() with different data for subsequent hits.
-//
-// Thread safety: The timeout goroutine in evaluate() means a timed-out goroutine
-// could still be reading the cache while the next request's goroutine writes to it.
-// RWMutex allows concurrent reads (the common case) with exclusive writes.
-type programCache struct {
-	mu       sync.RWMutex
-	programs map[string]cel.Program
-}
-
-func newProgramCache() *programCache {
-	return &programCache{programs: make(map[string]cel.Program)}
-}
-
-// get returns a cached program and true if found, or nil and false if not.
-func (c *programCache) get(key string) (cel.Program, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	prg, ok := c.programs[key]
-	return prg, ok
-}
-
-// put stores a compiled program. If the cache is full, the entry is silently
-// dropped — the caller will just recompile next time, which is the same cost
-// as before caching existed.
-func (c *programCache) put(key string, prg cel.Program) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if len(c.programs) >= maxCacheSize {
-		return
-	}
-	c.programs[key] = prg
-}
-
-// cacheKey builds a lookup key from expression and sorted variable names.
-// Uses \n as separator because NDJSON lines cannot contain literal newlines,
-// so no expression received over the protocol can collide with the separator.
-func cacheKey(expr string, varNames []string) string {
+// cacheKey builds a lookup key from engine, expression, and sorted variable
+// names. Prefixing with the engine name keeps the two engines' compiled
+// programs in separate namespaces of the same LRU. Uses \n as separator.
+// NDJSON lines cannot contain a literal newline byte, but a JSON "\n" escape
+// still decodes to one, so an expression or variable name containing a
+// newline can in principle share a key with a different (expr, vars) pair.
+func cacheKey(engineName, expr string, varNames []string) string {
 	sorted := make([]string, len(varNames))
 	copy(sorted, varNames)
 	sort.Strings(sorted)
-	return expr + "\n" + strings.Join(sorted, ",")
+	return engineName + "\n" + expr + "\n" + strings.Join(sorted, ",")
 }
 
 func main() {
 	writer := bufio.NewWriter(os.Stdout)
 	reader := bufio.NewScanner(os.Stdin)
-	cache := newProgramCache()
+	cache := newProgramCacheFromEnv()
 
 	// 10 MB buffer for large API response payloads in "data" field
 	const maxTokenSize = 10 * 1024 * 1024
@@ -125,18 +114,45 @@ func main() {
 		var req Request
 		if err := json.Unmarshal([]byte(line), &req); err != nil {
 			// Malformed JSON - send error with empty ID
-			resp := Response{ID: "", OK: false, Error: fmt.Sprintf("invalid JSON: %v", err)}
+			cache.recordError(ErrInvalidJSON)
+			resp := Response{ID: "", OK: false, Code: ErrInvalidJSON, Error: fmt.Sprintf("invalid JSON: %v", err)}
 			writeJSON(writer, resp)
 			continue
 		}
 
-		resp := evaluate(req, cache)
-		if err := writeJSON(writer, resp); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to write response for %s: %v\n", req.ID, err)
+		switch req.Op {
+		case "stats":
+			if err := writeJSON(writer, cache.snapshot()); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write stats response: %v\n", err)
+			}
+		case "batch":
+			resp := evaluateBatch(req, cache)
+			if !resp.OK {
+				cache.recordError(resp.Code)
+			}
+			if err := writeJSON(writer, resp); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write batch response for %s: %v\n", req.ID, err)
+			}
+		default:
+			resp := evaluate(req, cache)
+			if !resp.OK {
+				cache.recordError(resp.Code)
+			}
+			if err := writeJSON(writer, resp); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write response for %s: %v\n", req.ID, err)
+			}
 		}
 	}
 
 	if err := reader.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			// The scanner can't recover mid-stream once a line exceeds its
+			// buffer, so there's no request ID to reply to individually;
+			// report the class before exiting so it's visible in counters.
+			cache.recordError(ErrPayloadTooLarge)
+			resp := Response{OK: false, Code: ErrPayloadTooLarge, Error: fmt.Sprintf("request payload exceeds %d byte limit", maxTokenSize)}
+			writeJSON(writer, resp)
+		}
 		fmt.Fprintf(os.Stderr, "scanner error: %v\n", err)
 		os.Exit(1)
 	}
@@ -170,68 +186,35 @@ func evaluate(req Request, cache *programCache) Response {
 
 	select {
 	case <-ctx.Done():
-		return Response{ID: req.ID, OK: false, Error: fmt.Sprintf("CEL evaluation timeout (%v)", evaluationTimeout)}
+		return Response{ID: req.ID, OK: false, Code: ErrTimeout, Error: fmt.Sprintf("CEL evaluation timeout (%v)", evaluationTimeout)}
 	case resp := <-resultCh:
 		return resp
 	}
 }
 
-// evaluateSync compiles and runs a CEL expression with the given data.
-// Compiled programs are cached by (expression, variable names) so that wildcard
-// expansions that evaluate the same expression thousands of times only compile once.
+// evaluateSync compiles and runs an expression with the given data. Compiled
+// programs are cached by (engine, expression, variable names) so that
+// wildcard expansions that evaluate the same expression thousands of times
+// only compile once.
 func evaluateSync(req Request, cache *programCache) Response {
-	// Collect variable names for cache key
+	eng, err := resolveEngine(req.Engine)
+	if err != nil {
+		return Response{ID: req.ID, OK: false, Code: codeOf(err), Error: err.Error()}
+	}
+
 	varNames := make([]string, 0, len(req.Data))
 	for key := range req.Data {
 		varNames = append(varNames, key)
 	}
-	key := cacheKey(req.Expr, varNames)
-
-	prg, cached := cache.get(key)
-	if !cached {
-		// Cache miss: create environment, compile expression, build program.
-		// DynType for all variables since JSON values can be any type.
-		opts := []cel.EnvOption{
-			cel.DefaultUTCTimeZone(true),
-		}
-		for _, name := range varNames {
-			opts = append(opts, cel.Variable(name, cel.DynType))
-		}
-
-		env, err := cel.NewEnv(opts...)
-		if err != nil {
-			return Response{ID: req.ID, OK: false, Error: fmt.Sprintf("CEL environment creation failed: %v", err)}
-		}
-
-		ast, issues := env.Compile(req.Expr)
-		if issues != nil && issues.Err() != nil {
-			return Response{ID: req.ID, OK: false, Error: fmt.Sprintf("CEL compile error in expression %q: %v", req.Expr, issues.Err())}
-		}
-
-		var prgErr error
-		prg, prgErr = env.Program(ast)
-		if prgErr != nil {
-			return Response{ID: req.ID, OK: false, Error: fmt.Sprintf("CEL program creation failed: %v", prgErr)}
-		}
-
-		cache.put(key, prg)
-	}
 
-	// cel.Program is stateless and thread-safe per cel-go docs — safe to call
-	// Eval() concurrently on a cached program from multiple timeout goroutines.
-	out, _, err := prg.Eval(req.Data)
+	prg, err := getProgram(cache, eng, req.Expr, varNames)
 	if err != nil {
-		return Response{ID: req.ID, OK: false, Error: fmt.Sprintf("CEL evaluation error: %v", err)}
-	}
-
-	// CEL expressions in api-parity must return boolean (true = values match).
-	if out.Type() != types.BoolType {
-		return Response{ID: req.ID, OK: false, Error: fmt.Sprintf("CEL expression must return boolean, got %v", out.Type())}
+		return Response{ID: req.ID, OK: false, Engine: eng.Name(), Code: codeOf(err), Error: err.Error()}
 	}
 
-	result, ok := out.Value().(bool)
-	if !ok {
-		return Response{ID: req.ID, OK: false, Error: "internal error: bool type but non-bool value"}
+	result, err := evalBool(prg, req.Data)
+	if err != nil {
+		return Response{ID: req.ID, OK: false, Engine: eng.Name(), Code: codeOf(err), Error: err.Error()}
 	}
-	return Response{ID: req.ID, OK: true, Result: &result}
+	return Response{ID: req.ID, OK: true, Engine: eng.Name(), Result: &result}
 }